@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -11,6 +12,33 @@ import (
 	"time"
 )
 
+// validRomExtensions are the file extensions uploadRom and the chunked
+// upload session handlers accept for a rom.
+var validRomExtensions = []string{".gba", ".gbc", ".gb", ".zip", ".7z"}
+
+// romDirReservedNames are entries fileNamesFromDirPath may return for
+// romPath+storePath that aren't actual roms — per-user server metadata
+// sidecars and the staging directory for in-flight chunked uploads. These
+// must never be listed or handed to a client as a rom.
+var romDirReservedNames = map[string]bool{
+	".sync_index.json":      true,
+	".rom_info_cache.json":  true,
+	".upload_sessions.json": true,
+	".uploads":              true,
+}
+
+// filterRomNames drops any reserved server-internal names out of a rom
+// listing before it's shown to a client.
+func filterRomNames(names []string) []string {
+	filtered := make([]string, 0, len(names))
+	for _, name := range names {
+		if !romDirReservedNames[name] {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
 // helloWorld provides a default landing page
 //
 //	@Summary		Hello world landing page
@@ -31,10 +59,13 @@ func helloWorld(w http.ResponseWriter, r *http.Request) { // intro message to sh
 //
 //	@Summary		Download save from server
 //	@Tags			gba
-//	@Description	Download save from server
+//	@Description	Download save from server. Accepts either a bearer token or a syncManifest-issued signed link (storePath, expires, sig query params)
 //	@Produce		octet-stream
-//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			Authorization	header		string	false	"Bearer Token"
 //	@Param			save			query		string	true	"Save to download"	example(unbound.sav)
+//	@Param			storePath		query		string	false	"storePath from a signed download link"
+//	@Param			expires			query		string	false	"Signed link expiry, unix seconds"
+//	@Param			sig				query		string	false	"Signed link signature"
 //	@Success		200				{string}	string
 //	@Failure		401				{string}	string
 //	@Failure		405				{string}	string
@@ -48,13 +79,19 @@ func downloadSave(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storePath, err := getStorePathFromClaims(r.Context())
+	storePath, err := resolveDownloadStorePath(r, "save", fname)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	currentPath, err := currentSaveFilePath(storePath, fname)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	data, err := readFileData(savePath + storePath + fname)
+	data, err := readFileData(currentPath)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -67,10 +104,13 @@ func downloadSave(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary		Download rom from server
 //	@Tags			gba
-//	@Description	Download rom from server
+//	@Description	Download rom from server. Accepts either a bearer token or a syncManifest-issued signed link (storePath, expires, sig query params)
 //	@Produce		application/x-gba-rom
-//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			Authorization	header		string	false	"Bearer Token"
 //	@Param			rom				query		string	true	"Rom to download"	example(unbound.gba)
+//	@Param			storePath		query		string	false	"storePath from a signed download link"
+//	@Param			expires			query		string	false	"Signed link expiry, unix seconds"
+//	@Param			sig				query		string	false	"Signed link signature"
 //	@Success		200				{string}	string
 //	@Failure		401				{string}	string
 //	@Failure		405				{string}	string
@@ -84,9 +124,9 @@ func downloadRom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storePath, err := getStorePathFromClaims(r.Context())
+	storePath, err := resolveDownloadStorePath(r, "rom", fname)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
@@ -103,12 +143,14 @@ func downloadRom(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary		Upload rom to server
 //	@Tags			gba
-//	@Description	Upload rom to server
+//	@Description	Upload rom to server. With strict=true, a .gba/.gbc/.gb rom with a bad header checksum is rejected up front
 //	@Param			Authorization	header		string	true	"Bearer Token"
 //	@Param			rom				formData	file	true	"Rom to Upload"
+//	@Param			strict			query		bool	false	"Reject roms with an invalid header checksum"
 //	@Success		200				{string}	string
 //	@Failure		401				{string}	string
 //	@Failure		405				{string}	string
+//	@Failure		422				{string}	string
 //	@Failure		500				{string}	string
 //	@Failure		501				{string}	string
 //	@Router			/api/rom/upload [post]
@@ -123,7 +165,6 @@ func uploadRom(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	validRomExtensions := []string{".gba", ".gbc", ".gb", ".zip", ".7z"}
 	fileExtension := filepath.Ext(handler.Filename)
 
 	if !slices.Contains(validRomExtensions, fileExtension) {
@@ -132,6 +173,15 @@ func uploadRom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Query().Get("strict") == "true" {
+		header, err := peekRomHeader(file, handler.Filename)
+		if err == nil && !header.ChecksumValid {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprintf(w, "rom header checksum invalid, refusing upload in strict mode")
+			return
+		}
+	}
+
 	storePath, err := getStorePathFromClaims(r.Context())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -143,15 +193,20 @@ func uploadRom(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	if err := invalidateSyncIndexEntry(storePath, handler.Filename); err != nil {
+		log.Println("Error invalidating sync index entry:", err)
+	}
 }
 
-// uploadSave receives and stores a save file on the server
+// uploadSave receives a save file and stores it as a new immutable snapshot
 //
 //	@Summary		Upload save to server
 //	@Tags			gba
-//	@Description	Upload save to server
+//	@Description	Upload save to server as a new versioned snapshot; "current" is repointed at it
 //	@Param			Authorization	header		string	true	"Bearer Token"
 //	@Param			save			formData	file	true	"Save to Upload"
+//	@Param			label			formData	string	false	"Optional label to attach to this snapshot"
 //	@Success		200				{string}	string
 //	@Failure		401				{string}	string
 //	@Failure		405				{string}	string
@@ -169,17 +224,27 @@ func uploadSave(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	data, err := io.ReadAll(file)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	storePath, err := getStorePathFromClaims(r.Context())
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	err = createOrOverwriteFileIfNotExists(savePath+storePath+handler.Filename, file)
+	err = saveSnapshot(storePath, handler.Filename, data, r.FormValue("label"))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	if err := invalidateSyncIndexEntry(storePath, handler.Filename); err != nil {
+		log.Println("Error invalidating sync index entry:", err)
+	}
 }
 
 // listAllRoms lists all rom files uploaded to server
@@ -208,7 +273,7 @@ func listAllRoms(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp, err := json.Marshal(fileNames)
+	resp, err := json.Marshal(filterRomNames(fileNames))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -238,13 +303,13 @@ func listAllSaves(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	fileNames, err := fileNamesFromDirPath(savePath + storePath)
+	saveNames, err := listSaveNames(storePath)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	resp, err := json.Marshal(fileNames)
+	resp, err := json.Marshal(saveNames)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// romHeaderReadSize is how much of a rom is read off disk to parse its
+// cartridge header; both the GBA and GB/GBC header layouts fit well within
+// this many leading bytes.
+const romHeaderReadSize = 512
+
+// romHeader is the parsed cartridge header of a GBA, GBC, or GB rom.
+type romHeader struct {
+	System         string `json:"system"`
+	InternalTitle  string `json:"internalTitle"`
+	GameCode       string `json:"gameCode,omitempty"`
+	MakerCode      string `json:"makerCode"`
+	HeaderChecksum byte   `json:"headerChecksum"`
+	ChecksumValid  bool   `json:"checksumValid"`
+	ROMSizeBytes   int64  `json:"romSizeBytes"`
+	RAMSizeBytes   int64  `json:"ramSizeBytes"`
+	CGBFlag        byte   `json:"cgbFlag"`
+}
+
+// romInfoCacheKey identifies the file a cached romHeader was parsed from,
+// so a changed file on disk invalidates the cache entry.
+type romInfoCacheKey struct {
+	Mtime int64 `json:"mtime"`
+	Size  int64 `json:"size"`
+}
+
+type romInfoCacheEntry struct {
+	Key    romInfoCacheKey `json:"key"`
+	Header romHeader       `json:"header"`
+}
+
+// romInfoIndexPath returns the path of the per-user JSON cache of parsed
+// rom headers.
+func romInfoIndexPath(storePath string) string {
+	return romPath + storePath + ".rom_info_cache.json"
+}
+
+// loadRomInfoCache reads the cached rom header index for storePath.
+func loadRomInfoCache(storePath string) (map[string]romInfoCacheEntry, error) {
+	cache := map[string]romInfoCacheEntry{}
+
+	data, err := os.ReadFile(romInfoIndexPath(storePath))
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// saveRomInfoCache persists the cached rom header index for storePath.
+func saveRomInfoCache(storePath string, cache map[string]romInfoCacheEntry) error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(romInfoIndexPath(storePath), data, 0644)
+}
+
+// romInfoHandler returns the parsed cartridge header of a stored rom, using a
+// cache keyed by the file's mtime and size so repeated calls are O(1)
+//
+//	@Summary		Get parsed cartridge header metadata for a rom
+//	@Tags			gba
+//	@Description	Parses the GBA/GBC/GB cartridge header of a stored rom (or the first rom entry of a zipped pack)
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			rom				query		string	true	"Rom to inspect"	example(unbound.gba)
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/rom/info [get]
+func romInfoHandler(w http.ResponseWriter, r *http.Request) {
+	fname := r.URL.Query().Get("rom")
+	if fname == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	path := romPath + storePath + fname
+	info, err := os.Stat(path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	cache, err := loadRomInfoCache(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	key := romInfoCacheKey{Mtime: info.ModTime().Unix(), Size: info.Size()}
+	if entry, ok := cache[fname]; ok && entry.Key == key {
+		writeJSON(w, entry.Header)
+		return
+	}
+
+	data, romName, err := romHeaderBytes(storePath, fname)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header, err := parseRomHeader(romName, data)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+
+	cache[fname] = romInfoCacheEntry{Key: key, Header: header}
+	if err := saveRomInfoCache(storePath, cache); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, header)
+}
+
+// romHeaderBytes returns the first romHeaderReadSize bytes to parse a
+// header from, transparently opening the first recognized rom entry when
+// fname is a zip archive.
+func romHeaderBytes(storePath, fname string) ([]byte, string, error) {
+	if strings.ToLower(filepath.Ext(fname)) == ".zip" {
+		entries, err := listArchiveEntries(romPath + storePath + fname)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, entry := range entries {
+			if entry.System == "" {
+				continue
+			}
+			data, err := readArchiveEntryPrefix(romPath+storePath+fname, entry.Name, romHeaderReadSize)
+			if err != nil {
+				return nil, "", err
+			}
+			return data, entry.Name, nil
+		}
+		return nil, "", fmt.Errorf("no recognized rom entry found in %s", fname)
+	}
+
+	data, err := readFilePrefix(romPath+storePath+fname, romHeaderReadSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, fname, nil
+}
+
+// readFilePrefix reads up to n leading bytes of a file.
+func readFilePrefix(path string, n int) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, n)
+	read, err := f.Read(buf)
+	if err != nil && read == 0 {
+		return nil, err
+	}
+	return buf[:read], nil
+}
+
+// parseRomHeader dispatches to the GBA or GB/GBC header parser based on
+// the rom's file extension.
+func parseRomHeader(name string, data []byte) (romHeader, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gba":
+		return parseGBAHeader(data)
+	case ".gbc", ".gb":
+		return parseGBHeader(data, strings.ToLower(filepath.Ext(name)) == ".gbc")
+	default:
+		return romHeader{}, fmt.Errorf("unrecognized rom extension for %s", name)
+	}
+}
+
+// parseGBAHeader parses a GBA cartridge header, per the format documented
+// in GBATEK, from the first 0xC0 bytes of a rom image.
+func parseGBAHeader(data []byte) (romHeader, error) {
+	if len(data) < 0xC0 {
+		return romHeader{}, fmt.Errorf("rom too small to contain a GBA header")
+	}
+
+	var sum byte
+	for _, b := range data[0xA0:0xBD] {
+		sum -= b
+	}
+	sum -= 0x19
+	checksum := data[0xBD]
+
+	return romHeader{
+		System:         "gba",
+		InternalTitle:  strings.TrimRight(string(data[0xA0:0xAC]), "\x00"),
+		GameCode:       string(data[0xAC:0xB0]),
+		MakerCode:      string(data[0xB0:0xB2]),
+		HeaderChecksum: checksum,
+		ChecksumValid:  checksum == sum,
+		CGBFlag:        0,
+	}, nil
+}
+
+// parseGBHeader parses a GB/GBC cartridge header, per the format
+// documented in Pan Docs, from the first 0x150 bytes of a rom image.
+func parseGBHeader(data []byte, cgb bool) (romHeader, error) {
+	if len(data) < 0x150 {
+		return romHeader{}, fmt.Errorf("rom too small to contain a GB header")
+	}
+
+	var sum byte
+	for _, b := range data[0x134:0x14D] {
+		sum = sum - b - 1
+	}
+	checksum := data[0x14D]
+
+	system := "gb"
+	if cgb {
+		system = "gbc"
+	}
+
+	return romHeader{
+		System:         system,
+		InternalTitle:  strings.TrimRight(string(bytes.TrimRight(data[0x134:0x144], "\x00")), " "),
+		MakerCode:      fmt.Sprintf("%02X%02X", data[0x144], data[0x145]),
+		HeaderChecksum: checksum,
+		ChecksumValid:  checksum == sum,
+		ROMSizeBytes:   gbROMSize(data[0x148]),
+		RAMSizeBytes:   gbRAMSize(data[0x149]),
+		CGBFlag:        data[0x143],
+	}, nil
+}
+
+// gbROMSize converts a GB/GBC header ROM size code into a byte count.
+func gbROMSize(code byte) int64 {
+	if code > 8 {
+		return 0
+	}
+	return (32 << 10) << code
+}
+
+// gbRAMSize converts a GB/GBC header RAM size code into a byte count.
+func gbRAMSize(code byte) int64 {
+	switch code {
+	case 0:
+		return 0
+	case 1:
+		return 2 << 10
+	case 2:
+		return 8 << 10
+	case 3:
+		return 32 << 10
+	case 4:
+		return 128 << 10
+	case 5:
+		return 64 << 10
+	default:
+		return 0
+	}
+}
+
+// peekRomHeader parses the cartridge header directly off an in-flight
+// upload, then rewinds it so the caller can still stream it to disk.
+func peekRomHeader(file multipart.File, filename string) (romHeader, error) {
+	buf := make([]byte, romHeaderReadSize)
+	read, err := io.ReadFull(file, buf)
+	if err != nil && read == 0 {
+		return romHeader{}, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return romHeader{}, err
+	}
+
+	return parseRomHeader(filename, buf[:read])
+}
+
+// writeJSON marshals v as the JSON response body, matching the error
+// handling convention used by the other list/info handlers in this package.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
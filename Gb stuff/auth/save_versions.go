@@ -0,0 +1,370 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// retainMostRecent is the number of most recent snapshots kept
+// unconditionally by the retention policy, regardless of age.
+const retainMostRecent = 5
+
+// retainDailyFor is how far back the retention policy keeps one snapshot
+// per day, beyond the most-recent window.
+const retainDailyFor = 7 * 24 * time.Hour
+
+// saveVersion describes a single immutable snapshot of a save file.
+type saveVersion struct {
+	Timestamp int64  `json:"timestamp"`
+	SHA256    string `json:"sha256"`
+	Size      int64  `json:"size"`
+	Label     string `json:"label,omitempty"`
+}
+
+// saveVersionDir returns the directory holding every snapshot of a given
+// save name, e.g. savePath/<user>/unbound.sav/.
+func saveVersionDir(storePath, name string) string {
+	return savePath + storePath + name + "/"
+}
+
+// currentPointerPath returns the path of the small file that tracks which
+// snapshot is the current one for a save name.
+func currentPointerPath(storePath, name string) string {
+	return saveVersionDir(storePath, name) + "current"
+}
+
+// legacySavePath returns where a save written by the pre-versioning
+// createOrOverwriteFileIfNotExists path would live.
+func legacySavePath(storePath, name string) string {
+	return savePath + storePath + name
+}
+
+// migrateLegacySaveIfNeeded one-time-migrates a flat save written before
+// snapshot versioning existed into a version directory with an initial
+// snapshot, so it becomes visible and downloadable through the new model.
+// It's a no-op once a version directory already has a "current" pointer,
+// and returns an error satisfying os.IsNotExist if there's no legacy save
+// to migrate either.
+func migrateLegacySaveIfNeeded(storePath, name string) error {
+	if _, err := os.Stat(currentPointerPath(storePath, name)); err == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(legacySavePath(storePath, name))
+	if err != nil {
+		return err
+	}
+
+	// The legacy save is a flat file at the exact path saveVersionDir needs
+	// to MkdirAll into, so it must be out of the way before that happens.
+	if err := os.Remove(legacySavePath(storePath, name)); err != nil {
+		return err
+	}
+
+	return saveSnapshot(storePath, name, data, "migrated from legacy save")
+}
+
+// currentSaveFilePath resolves a save name to the snapshot file it
+// currently points at, migrating a legacy flat save in place first if
+// needed.
+func currentSaveFilePath(storePath, name string) (string, error) {
+	if err := migrateLegacySaveIfNeeded(storePath, name); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	pointer, err := os.ReadFile(currentPointerPath(storePath, name))
+	if err != nil {
+		return "", err
+	}
+	return saveVersionDir(storePath, name) + strings.TrimSpace(string(pointer)), nil
+}
+
+// snapshotFileName builds the on-disk name of a snapshot from its creation
+// time and content hash: "<unix-timestamp>-<sha256>.sav".
+func snapshotFileName(timestamp int64, sum string) string {
+	return fmt.Sprintf("%d-%s.sav", timestamp, sum)
+}
+
+// parseSnapshotFileName extracts the timestamp and hash back out of a
+// snapshot's on-disk name, skipping files that don't match the pattern
+// (e.g. "current" or a version's optional label sidecar).
+func parseSnapshotFileName(fname string) (timestamp int64, sum string, ok bool) {
+	base := strings.TrimSuffix(fname, ".sav")
+	if base == fname {
+		return 0, "", false
+	}
+	parts := strings.SplitN(base, "-", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return ts, parts[1], true
+}
+
+// labelSidecarPath returns the path of the optional file storing a
+// client-supplied label for a snapshot.
+func labelSidecarPath(storePath, name, snapshotFile string) string {
+	return saveVersionDir(storePath, name) + snapshotFile + ".label"
+}
+
+// listSaveVersions returns every snapshot of a save name, oldest first.
+func listSaveVersions(storePath, name string) ([]saveVersion, error) {
+	entries, err := os.ReadDir(saveVersionDir(storePath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]saveVersion, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".label") {
+			continue
+		}
+		ts, sum, ok := parseSnapshotFileName(entry.Name())
+		if !ok {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		label, _ := os.ReadFile(labelSidecarPath(storePath, name, entry.Name()))
+		versions = append(versions, saveVersion{
+			Timestamp: ts,
+			SHA256:    sum,
+			Size:      info.Size(),
+			Label:     string(label),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Timestamp < versions[j].Timestamp })
+	return versions, nil
+}
+
+// applySaveRetentionPolicy prunes old snapshots of a save, keeping the
+// retainMostRecent newest ones plus one per day for the retainDailyFor
+// window beyond that.
+func applySaveRetentionPolicy(storePath, name string) error {
+	versions, err := listSaveVersions(storePath, name)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= retainMostRecent {
+		return nil
+	}
+
+	current, err := os.ReadFile(currentPointerPath(storePath, name))
+	if err != nil {
+		return err
+	}
+	currentFile := strings.TrimSpace(string(current))
+
+	keep := map[int64]bool{}
+	for _, v := range versions[len(versions)-retainMostRecent:] {
+		keep[v.Timestamp] = true
+	}
+
+	dailyCutoff := time.Now().Add(-retainDailyFor).Unix()
+	keptDays := map[string]bool{}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v.Timestamp < dailyCutoff {
+			break
+		}
+		day := time.Unix(v.Timestamp, 0).UTC().Format("2006-01-02")
+		if !keptDays[day] {
+			keptDays[day] = true
+			keep[v.Timestamp] = true
+		}
+	}
+
+	for _, v := range versions {
+		if keep[v.Timestamp] {
+			continue
+		}
+		fname := snapshotFileName(v.Timestamp, v.SHA256)
+		if fname == currentFile {
+			continue
+		}
+		os.Remove(saveVersionDir(storePath, name) + fname)
+		os.Remove(labelSidecarPath(storePath, name, fname))
+	}
+	return nil
+}
+
+// saveSnapshot writes a new immutable snapshot for a save name, repoints
+// "current" at it, and applies the retention policy.
+func saveSnapshot(storePath, name string, data []byte, label string) error {
+	if err := os.MkdirAll(saveVersionDir(storePath, name), 0755); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	fname := snapshotFileName(time.Now().Unix(), hex.EncodeToString(sum[:]))
+
+	if err := os.WriteFile(saveVersionDir(storePath, name)+fname, data, 0644); err != nil {
+		return err
+	}
+	if label != "" {
+		if err := os.WriteFile(labelSidecarPath(storePath, name, fname), []byte(label), 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(currentPointerPath(storePath, name), []byte(fname), 0644); err != nil {
+		return err
+	}
+
+	return applySaveRetentionPolicy(storePath, name)
+}
+
+// listSaveNames lists every save a user has, including ones that still
+// only exist as a legacy flat file and haven't been migrated yet.
+func listSaveNames(storePath string) ([]string, error) {
+	entries, err := os.ReadDir(savePath + storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	versioned := map[string]bool{}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			versioned[entry.Name()] = true
+			names = append(names, entry.Name())
+		}
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !versioned[entry.Name()] {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// saveVersionsHandler lists the snapshot history of a save
+//
+//	@Summary		List the snapshot history of a save
+//	@Tags			gba
+//	@Description	Returns every retained snapshot of a save, ordered oldest to newest
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			save			query		string	true	"Save to list versions for"	example(unbound.sav)
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/save/versions [get]
+func saveVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("save")
+	if name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	name = filepath.Clean(name)
+	if err := migrateLegacySaveIfNeeded(storePath, name); err != nil && !os.IsNotExist(err) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := listSaveVersions(storePath, name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(versions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// saveRestore repoints a save's "current" snapshot to an earlier version
+//
+//	@Summary		Restore a save to a previous snapshot
+//	@Tags			gba
+//	@Description	Atomically repoints a save's current version at an earlier, immutable snapshot
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			save			query		string	true	"Save to restore"				example(unbound.sav)
+//	@Param			version			query		string	true	"Snapshot timestamp to restore"	example(1690000000)
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		404				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/save/restore [post]
+func saveRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("save")
+	timestamp, err := strconv.ParseInt(r.URL.Query().Get("version"), 10, 64)
+	if name == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	name = filepath.Clean(name)
+	if err := migrateLegacySaveIfNeeded(storePath, name); err != nil && !os.IsNotExist(err) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := listSaveVersions(storePath, name)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var target *saveVersion
+	for i := range versions {
+		if versions[i].Timestamp == timestamp {
+			target = &versions[i]
+			break
+		}
+	}
+	if target == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	fname := snapshotFileName(target.Timestamp, target.SHA256)
+	tempPointer := currentPointerPath(storePath, name) + ".tmp"
+	if err := os.WriteFile(tempPointer, []byte(fname), 0644); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tempPointer, currentPointerPath(storePath, name)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := invalidateSyncIndexEntry(storePath, name); err != nil {
+		log.Println("Error invalidating sync index entry:", err)
+	}
+}
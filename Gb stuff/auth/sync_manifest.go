@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadURLTTL is how long a pre-signed download URL returned from the
+// sync manifest stays valid.
+const downloadURLTTL = 5 * time.Minute
+
+// syncEntry describes a single file's identity as known to the client or
+// the server.
+type syncEntry struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+}
+
+// syncManifestResponse is the diff returned to the client describing what
+// it should upload, download, or leave alone.
+type syncManifestResponse struct {
+	Upload   []string          `json:"upload"`
+	Download []syncDownloadRef `json:"download"`
+	UpToDate []string          `json:"upToDate"`
+}
+
+// syncDownloadRef pairs a file the client is missing or has stale with a
+// short-lived signed URL it can fetch it from.
+type syncDownloadRef struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+var (
+	syncIndexMu sync.Mutex
+
+	// downloadURLSigningKey signs the pre-signed download URLs handed out by
+	// syncManifest. It is process-local, so links do not survive a restart.
+	downloadURLSigningKey = randomSigningKey()
+)
+
+// randomSigningKey generates a random key used to sign short-lived
+// download URLs for the lifetime of the process.
+func randomSigningKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// syncIndexPath returns the path of the per-user JSON cache of file
+// identities under storePath.
+func syncIndexPath(storePath string) string {
+	return romPath + storePath + ".sync_index.json"
+}
+
+// loadSyncIndex reads the cached file identity index for storePath,
+// returning an empty map if none exists yet.
+func loadSyncIndex(storePath string) (map[string]syncEntry, error) {
+	index := map[string]syncEntry{}
+
+	data, err := os.ReadFile(syncIndexPath(storePath))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// saveSyncIndex persists the file identity index for storePath.
+func saveSyncIndex(storePath string, index map[string]syncEntry) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(syncIndexPath(storePath), data, 0644)
+}
+
+// invalidateSyncIndexEntry drops a single file from the cached sync index,
+// forcing it to be rehashed on the next manifest request.
+func invalidateSyncIndexEntry(storePath, name string) error {
+	syncIndexMu.Lock()
+	defer syncIndexMu.Unlock()
+
+	index, err := loadSyncIndex(storePath)
+	if err != nil {
+		return err
+	}
+	delete(index, name)
+	return saveSyncIndex(storePath, index)
+}
+
+// serverSyncEntry looks up the cached identity for name at path, rehashing
+// it if the file is new or its mtime/size has changed since it was cached.
+func serverSyncEntry(name, path string, index map[string]syncEntry) (syncEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return syncEntry{}, err
+	}
+
+	cached, ok := index[name]
+	if ok && cached.Mtime == info.ModTime().Unix() && cached.Size == info.Size() {
+		return cached, nil
+	}
+
+	data, err := readFileData(path)
+	if err != nil {
+		return syncEntry{}, err
+	}
+	sum := sha256.Sum256(data)
+
+	entry := syncEntry{
+		Name:   name,
+		SHA256: hex.EncodeToString(sum[:]),
+		Size:   info.Size(),
+		Mtime:  info.ModTime().Unix(),
+	}
+	index[name] = entry
+	return entry, nil
+}
+
+// syncManifest computes a batch diff of what a client should upload or
+// download to bring its rom/save collection in sync with the server
+//
+//	@Summary		Compute a batch sync plan for rom/save files
+//	@Tags			gba
+//	@Description	Accepts the client's known file identities and returns which files to upload, download, or leave alone
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			request			body		[]syncEntry	true	"Client-known file entries"
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/sync/manifest [post]
+func syncManifest(w http.ResponseWriter, r *http.Request) {
+	var clientEntries []syncEntry
+	if err := json.NewDecoder(r.Body).Decode(&clientEntries); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	syncIndexMu.Lock()
+	defer syncIndexMu.Unlock()
+
+	index, err := loadSyncIndex(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	clientByName := make(map[string]syncEntry, len(clientEntries))
+	for _, entry := range clientEntries {
+		clientByName[entry.Name] = entry
+	}
+
+	romNames, err := fileNamesFromDirPath(romPath + storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	romNames = filterRomNames(romNames)
+	saveNames, err := listSaveNames(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp := syncManifestResponse{
+		Upload:   []string{},
+		Download: []syncDownloadRef{},
+		UpToDate: []string{},
+	}
+	seen := map[string]bool{}
+
+	for _, name := range romNames {
+		serverEntry, err := serverSyncEntry(name, romPath+storePath+name, index)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		seen[name] = true
+		reconcileSyncEntry(&resp, clientByName, name, serverEntry, "rom", storePath)
+	}
+	for _, name := range saveNames {
+		currentPath, err := currentSaveFilePath(storePath, name)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		serverEntry, err := serverSyncEntry(name, currentPath, index)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		seen[name] = true
+		reconcileSyncEntry(&resp, clientByName, name, serverEntry, "save", storePath)
+	}
+
+	for name := range clientByName {
+		if !seen[name] {
+			resp.Upload = append(resp.Upload, name)
+		}
+	}
+
+	if err := saveSyncIndex(storePath, index); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// reconcileSyncEntry decides whether a server-known file should be
+// downloaded by the client, uploaded by the client, or is already in sync,
+// based on the hash the client reported for it (if any). When the hashes
+// differ, the client uploads if its mtime is newer and downloads otherwise.
+func reconcileSyncEntry(resp *syncManifestResponse, clientByName map[string]syncEntry, name string, server syncEntry, kind, storePath string) {
+	client, known := clientByName[name]
+	delete(clientByName, name)
+
+	if !known {
+		resp.Download = append(resp.Download, syncDownloadRef{Name: name, URL: signedDownloadURL(kind, name, storePath)})
+		return
+	}
+	if client.SHA256 == server.SHA256 {
+		resp.UpToDate = append(resp.UpToDate, name)
+		return
+	}
+	if client.Mtime > server.Mtime {
+		resp.Upload = append(resp.Upload, name)
+		return
+	}
+	resp.Download = append(resp.Download, syncDownloadRef{Name: name, URL: signedDownloadURL(kind, name, storePath)})
+}
+
+// signedDownloadURL builds a short-lived signed download link for a rom or
+// save file, good for downloadURLTTL from now. The link carries its own
+// signed storePath so downloadRom/downloadSave can serve it without
+// requiring the caller's own bearer token.
+func signedDownloadURL(kind, name, storePath string) string {
+	expires := time.Now().Add(downloadURLTTL).Unix()
+	sig := signDownloadToken(kind, name, storePath, expires)
+
+	values := url.Values{}
+	values.Set(kind, name)
+	values.Set("storePath", storePath)
+	values.Set("expires", strconv.FormatInt(expires, 10))
+	values.Set("sig", sig)
+	return fmt.Sprintf("/api/%s/download?%s", kind, values.Encode())
+}
+
+// signDownloadToken computes an HMAC-SHA256 signature over a download
+// link's parameters so it can be verified without a server-side lookup.
+func signDownloadToken(kind, name, storePath string, expires int64) string {
+	mac := hmac.New(sha256.New, downloadURLSigningKey)
+	mac.Write([]byte(strings.Join([]string{kind, name, storePath, strconv.FormatInt(expires, 10)}, "|")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyDownloadToken recomputes a download link's signature and reports
+// whether it matches, without leaking timing information about partial
+// matches.
+func verifyDownloadToken(kind, name, storePath string, expires int64, sig string) bool {
+	given, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, downloadURLSigningKey)
+	mac.Write([]byte(strings.Join([]string{kind, name, storePath, strconv.FormatInt(expires, 10)}, "|")))
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// resolveDownloadStorePath authenticates a rom/save download either via a
+// signed, time-limited link (sig+expires+storePath query params, as handed
+// out by syncManifest) or by falling back to the caller's bearer token.
+func resolveDownloadStorePath(r *http.Request, kind, name string) (string, error) {
+	sig := r.URL.Query().Get("sig")
+	if sig == "" {
+		return getStorePathFromClaims(r.Context())
+	}
+
+	expires, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", fmt.Errorf("download link expired or malformed")
+	}
+
+	storePath := r.URL.Query().Get("storePath")
+	if !verifyDownloadToken(kind, name, storePath, expires, sig) {
+		return "", fmt.Errorf("invalid download link signature")
+	}
+	return storePath, nil
+}
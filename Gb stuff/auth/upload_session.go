@@ -0,0 +1,359 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// uploadSession tracks the progress of a single resumable chunked upload.
+type uploadSession struct {
+	ID        string `json:"id"`
+	StorePath string `json:"storePath"`
+	Filename  string `json:"filename"`
+	TotalSize int64  `json:"totalSize"`
+	SHA256    string `json:"sha256"`
+	Offset    int64  `json:"offset"`
+	TempPath  string `json:"tempPath"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+var (
+	uploadSessionsMu sync.Mutex
+)
+
+// uploadSessionIndexPath returns the path of the JSON index tracking
+// in-flight upload sessions for a given user's storePath.
+func uploadSessionIndexPath(storePath string) string {
+	return romPath + storePath + ".upload_sessions.json"
+}
+
+// uploadTempDir returns the directory in-flight chunked uploads are
+// staged in. It's a subdirectory of the user's rom dir rather than a
+// sibling file so a partial upload never surfaces as a phantom rom in
+// listAllRoms/syncManifest, which only enumerate romPath+storePath itself.
+func uploadTempDir(storePath string) string {
+	return romPath + storePath + ".uploads/"
+}
+
+// loadUploadSessions reads the session index for storePath, returning an
+// empty map if the index does not exist yet.
+func loadUploadSessions(storePath string) (map[string]*uploadSession, error) {
+	sessions := map[string]*uploadSession{}
+
+	data, err := os.ReadFile(uploadSessionIndexPath(storePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// saveUploadSessions persists the session index for storePath.
+func saveUploadSessions(storePath string, sessions map[string]*uploadSession) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploadSessionIndexPath(storePath), data, 0644)
+}
+
+// initUploadSession begins a new resumable, chunked ROM upload
+//
+//	@Summary		Begin a resumable chunked rom upload
+//	@Tags			gba
+//	@Description	Allocates a session ID for a chunked upload of a rom larger than the single-request limit
+//	@Accept			json
+//	@Produce		json
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			request			body		object	true	"filename, totalSize and sha256 of the file to upload"
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/rom/upload/init [post]
+func initUploadSession(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Filename  string `json:"filename"`
+		TotalSize int64  `json:"totalSize"`
+		SHA256    string `json:"sha256"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Filename == "" || req.TotalSize <= 0 || req.SHA256 == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	req.Filename = filepath.Base(req.Filename)
+	if !slices.Contains(validRomExtensions, filepath.Ext(req.Filename)) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "File not in gba format, expected extensions are .gba/.gbc/.gb/.zip/.7z")
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uploadSessionsMu.Lock()
+	defer uploadSessionsMu.Unlock()
+
+	sessions, err := loadUploadSessions(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sessionID, err := newSessionID()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session := &uploadSession{
+		ID:        sessionID,
+		StorePath: storePath,
+		Filename:  req.Filename,
+		TotalSize: req.TotalSize,
+		SHA256:    req.SHA256,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	if err := os.MkdirAll(uploadTempDir(storePath), 0755); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	session.TempPath = uploadTempDir(storePath) + session.ID + ".part"
+
+	tempFile, err := os.Create(session.TempPath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tempFile.Close()
+
+	sessions[session.ID] = session
+	if err := saveUploadSessions(storePath, sessions); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := json.Marshal(session)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+}
+
+// uploadChunk accepts the next chunk of a resumable upload, or reports the
+// currently committed offset when called with HEAD
+//
+//	@Summary		Upload or query a chunk of a resumable rom upload
+//	@Tags			gba
+//	@Description	PUT streams the next chunk at the given offset into the session's temp file; HEAD returns the committed offset
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			session			query		string	true	"Upload session ID"
+//	@Param			offset			query		string	true	"Byte offset this chunk starts at"
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		409				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/rom/upload/chunk [put]
+//	@Router			/api/rom/upload/chunk [head]
+func uploadChunk(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uploadSessionsMu.Lock()
+	defer uploadSessionsMu.Unlock()
+
+	sessions, err := loadUploadSessions(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, ok := sessions[sessionID]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		w.Header().Set("X-Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if offset != session.Offset {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	tempFile, err := os.OpenFile(session.TempPath, os.O_WRONLY, 0644)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer tempFile.Close()
+
+	if _, err := tempFile.Seek(offset, io.SeekStart); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	remaining := session.TotalSize - session.Offset
+	r.Body = http.MaxBytesReader(w, r.Body, remaining)
+
+	written, err := io.Copy(tempFile, r.Body)
+	if err != nil {
+		log.Println("Error writing upload chunk:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session.Offset += written
+	if err := saveUploadSessions(storePath, sessions); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Upload-Offset", strconv.FormatInt(session.Offset, 10))
+}
+
+// finishUploadSession verifies and commits a completed chunked upload
+//
+//	@Summary		Finish a resumable chunked rom upload
+//	@Tags			gba
+//	@Description	Verifies the assembled file's SHA-256 against the declared digest and moves it into place
+//	@Param			Authorization	header		string	true	"Bearer Token"
+//	@Param			session			query		string	true	"Upload session ID"
+//	@Success		200				{string}	string
+//	@Failure		400				{string}	string
+//	@Failure		401				{string}	string
+//	@Failure		422				{string}	string
+//	@Failure		500				{string}	string
+//	@Router			/api/rom/upload/finish [post]
+func finishUploadSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	storePath, err := getStorePathFromClaims(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	uploadSessionsMu.Lock()
+	defer uploadSessionsMu.Unlock()
+
+	sessions, err := loadUploadSessions(storePath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, ok := sessions[sessionID]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if session.Offset != session.TotalSize {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "upload incomplete: received %d of %d bytes", session.Offset, session.TotalSize)
+		return
+	}
+
+	sum, err := sha256File(session.TempPath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if sum != session.SHA256 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "sha256 mismatch: expected %s, got %s", session.SHA256, sum)
+		return
+	}
+
+	destination := romPath + storePath + session.Filename
+	if err := os.Rename(session.TempPath, destination); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	delete(sessions, sessionID)
+	if err := saveUploadSessions(storePath, sessions); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// sha256File streams a file's contents through SHA-256 without loading it
+// fully into memory.
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// newSessionID generates a random hex identifier for an upload session.
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}